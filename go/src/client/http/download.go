@@ -0,0 +1,245 @@
+package client
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// PartSuffix marks a network download that hasn't finished (and
+// sha256-verified) yet. Range requests resume straight into this file
+// rather than restarting the transfer from scratch. It's exported so
+// callers clearing out old networks can recognise (and keep) a partial
+// download that's still in progress for the sha they're about to fetch.
+const PartSuffix = ".part"
+
+// DownloadNetwork fetches the network weights for sha into path,
+// resuming from path+PartSuffix if a previous attempt left one behind,
+// and refusing to publish the result unless its sha256 matches sha.
+func DownloadNetwork(ctx context.Context, httpClient *http.Client, hostname string, path string, sha string) error {
+	return downloadAndVerify(ctx, httpClient, hostname+"/get_network?sha="+sha, path, sha)
+}
+
+// downloadAndVerify streams url into path+PartSuffix (resuming if that
+// file already exists), verifies the result against sha, and atomically
+// renames it into place. A single corrupt attempt is retried once from
+// scratch before giving up.
+func downloadAndVerify(ctx context.Context, httpClient *http.Client, url string, path string, sha string) error {
+	partPath := path + PartSuffix
+
+	for attempt := 0; attempt < 2; attempt++ {
+		if err := downloadToPart(ctx, httpClient, url, partPath); err != nil {
+			return err
+		}
+
+		if err := verifyFileSha256(partPath, sha); err != nil {
+			// Corrupt part file: drop it and retry once from scratch.
+			os.Remove(partPath)
+			if attempt == 0 {
+				continue
+			}
+			return err
+		}
+
+		return os.Rename(partPath, path)
+	}
+	return fmt.Errorf("failed to download %s: too many corrupt attempts", sha)
+}
+
+// downloadToPart streams url into partPath, resuming via a Range request
+// if partPath already has some bytes in it.
+func downloadToPart(ctx context.Context, httpClient *http.Client, url string, partPath string) error {
+	var startOffset int64
+	if info, err := os.Stat(partPath); err == nil {
+		startOffset = info.Size()
+	}
+
+	request, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	if startOffset > 0 {
+		request.Header.Set("Range", fmt.Sprintf("bytes=%d-", startOffset))
+	}
+
+	resp, err := httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		flags |= os.O_APPEND
+	case http.StatusOK:
+		// Server ignored/doesn't support our Range request; start over.
+		startOffset = 0
+		flags |= os.O_TRUNC
+	default:
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("download failed: %d %s", resp.StatusCode, body)
+	}
+
+	out, err := os.OpenFile(partPath, flags, 0644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, resp.Body)
+	return err
+}
+
+// verifyFileSha256 hashes the decompressed contents of path and compares
+// them against the expected sha. Files on disk are the gzip-compressed
+// blob exactly as downloaded, but the server addresses a network by the
+// sha256 of its decompressed weights (see uploadNetworkHandler), so the
+// comparison has to decompress first or it will never match.
+func verifyFileSha256(path string, sha string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, gz); err != nil {
+		return err
+	}
+	got := hex.EncodeToString(h.Sum(nil))
+	if got != sha {
+		return fmt.Errorf("sha256 mismatch for %s: got %s, want %s", path, got, sha)
+	}
+	return nil
+}
+
+// BundleFile describes one network shard in a bundle manifest.
+type BundleFile struct {
+	Sha    string `json:"sha"`
+	Size   int64  `json:"size"`
+	Sha256 string `json:"sha256"`
+	Url    string `json:"url"`
+}
+
+// fetchBundleManifest asks the server which files make up the bundle for
+// the given shas.
+func fetchBundleManifest(ctx context.Context, httpClient *http.Client, hostname string, shas []string) ([]BundleFile, error) {
+	url := hostname + "/bundle?"
+	for i, sha := range shas {
+		if i > 0 {
+			url += "&"
+		}
+		url += "sha=" + sha
+	}
+
+	request, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := httpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("bundle manifest fetch failed: %d %s", resp.StatusCode, body)
+	}
+
+	var manifest []BundleFile
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// DownloadBundle fetches the bundle manifest for shas and downloads every
+// shard that's missing from dir, using up to workers concurrent
+// downloads. Each shard is verified against its advertised sha256 exactly
+// like a single DownloadNetwork call.
+func DownloadBundle(ctx context.Context, httpClient *http.Client, hostname string, dir string, shas []string, workers int) error {
+	manifest, err := fetchBundleManifest(ctx, httpClient, hostname, shas)
+	if err != nil {
+		return err
+	}
+
+	if workers <= 0 {
+		workers = 1
+	}
+	jobs := make(chan BundleFile)
+	errs := make(chan error, len(manifest))
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for file := range jobs {
+				path := filepath.Join(dir, file.Sha)
+				if _, err := os.Stat(path); err == nil {
+					continue
+				}
+				errs <- downloadAndVerify(ctx, httpClient, file.Url, path, file.Sha256)
+			}
+		}()
+	}
+	for _, file := range manifest {
+		jobs <- file
+	}
+	close(jobs)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// VerifyNetworks checks every file already downloaded into dir against
+// its filename, which is taken to be the expected sha256, and removes any
+// that fail to verify so the caller can redownload them. In-progress
+// PartSuffix files are left alone so their downloads can resume.
+func VerifyNetworks(dir string) error {
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasSuffix(entry.Name(), PartSuffix) {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		if err := verifyFileSha256(path, entry.Name()); err != nil {
+			os.Remove(path)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+// Package client holds the HTTP glue shared by the training client's
+// commands: talking to /next_game, building multipart uploads, and
+// downloading network weight files.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// Params is the server's free-form list of extra engine flags for a game.
+// On the wire it is a JSON-encoded string (empty for none, otherwise a
+// JSON array serialized as text) rather than a bare JSON array, so it
+// needs custom unmarshaling.
+type Params []string
+
+func (p *Params) UnmarshalJSON(data []byte) error {
+	var raw string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if raw == "" {
+		*p = nil
+		return nil
+	}
+	var fields []string
+	if err := json.Unmarshal([]byte(raw), &fields); err != nil {
+		return err
+	}
+	*p = fields
+	return nil
+}
+
+// NextGameResponse is the JSON body returned by POST /next_game.
+type NextGameResponse struct {
+	Type         string `json:"type"`
+	TrainingId   int64  `json:"trainingId,omitempty"`
+	NetworkId    int64  `json:"networkId,omitempty"`
+	Sha          string `json:"sha"`
+	CandidateSha string `json:"candidateSha,omitempty"`
+	MatchGameId  int64  `json:"matchGameId,omitempty"`
+	Params       Params `json:"params"`
+	Flip         bool   `json:"flip,omitempty"`
+}
+
+// NextGame asks the server what to do next: play a training game or a
+// match game. It aborts early if ctx is cancelled.
+func NextGame(ctx context.Context, httpClient *http.Client, hostname string, params map[string]string) (NextGameResponse, error) {
+	var result NextGameResponse
+
+	data := url.Values{}
+	for key, val := range params {
+		data.Set(key, val)
+	}
+	request, err := http.NewRequestWithContext(ctx, "POST", hostname+"/next_game", bytes.NewReader([]byte(data.Encode())))
+	if err != nil {
+		return result, err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := httpClient.Do(request)
+	if err != nil {
+		return result, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return result, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return result, fmt.Errorf("next_game failed: %d %s", resp.StatusCode, body)
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// BuildUploadRequest builds a multipart/form-data POST request uploading
+// the file at path under the given form field name, alongside the extra
+// plain-text params. Callers that want it to respect cancellation should
+// wrap the result with request.WithContext(ctx) before sending it.
+func BuildUploadRequest(url string, params map[string]string, paramName, path string) (*http.Request, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile(paramName, path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = io.Copy(part, file); err != nil {
+		return nil, err
+	}
+
+	for key, val := range params {
+		if err := writer.WriteField(key, val); err != nil {
+			return nil, err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	request, err := http.NewRequest("POST", url, body)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", writer.FormDataContentType())
+	return request, nil
+}
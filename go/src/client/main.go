@@ -3,6 +3,7 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"errors"
 	"flag"
 	"fmt"
@@ -10,20 +11,38 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"client/http"
+	"client/uci"
 )
 
 var HOSTNAME = flag.String("hostname", "http://162.217.248.187", "Address of the server")
 var USER = flag.String("user", "", "Username")
 var PASSWORD = flag.String("password", "", "Password")
 var GPU = flag.Int("gpu", 0, "ID of the OpenCL device to use (-1 for no GPU)")
+var SHUTDOWN_TIMEOUT = flag.Duration("shutdown-timeout", 30*time.Second, "Grace period to let an in-flight game finish after SIGINT/SIGTERM before killing engines")
+var WORKERS = flag.Int("workers", 1, "Number of training games to run concurrently")
+var GPUS = flag.String("gpus", "", "Comma-separated OpenCL device IDs, assigned to workers round-robin (overrides --gpu when set)")
+
+// matchEngineTimeout bounds how long we'll wait for a single engine
+// response (uciok/readyok/bestmove) before giving up on a match game. A
+// hung engine shouldn't be able to wedge the worker forever.
+var matchEngineTimeout = 5 * time.Minute
+
+// matchAdjudicationThreshold is the number of plies without a reported
+// decisive result after which we adjudicate the game a draw.
+const matchAdjudicationThreshold = 450
 
 func getExtraParams() map[string]string {
 	return map[string]string{
@@ -33,50 +52,145 @@ func getExtraParams() map[string]string {
 	}
 }
 
-func uploadGame(httpClient *http.Client, path string, pgn string, nextGame client.NextGameResponse) error {
+// uploadJob is a completed training game waiting to be POSTed to
+// /upload_game. Workers hand these off to a single uploader goroutine
+// rather than uploading from within the worker itself.
+type uploadJob struct {
+	path     string
+	pgn      string
+	nextGame client.NextGameResponse
+}
+
+// runUploader drains jobs off uploads and uploads each one, retrying on
+// the server's end (5xx) with exponential backoff so a transient server
+// hiccup doesn't drop a finished game. It returns once uploads is closed
+// and drained.
+func runUploader(ctx context.Context, httpClient *http.Client, uploads <-chan uploadJob) {
+	for job := range uploads {
+		if err := uploadGame(ctx, httpClient, job.path, job.pgn, job.nextGame); err != nil {
+			log.Printf("Failed to upload %s: %v", job.path, err)
+		}
+	}
+}
+
+func uploadGame(ctx context.Context, httpClient *http.Client, path string, pgn string, nextGame client.NextGameResponse) error {
 	extraParams := getExtraParams()
 	extraParams["training_id"] = strconv.Itoa(int(nextGame.TrainingId))
 	extraParams["network_id"] = strconv.Itoa(int(nextGame.NetworkId))
 	extraParams["pgn"] = pgn
-	request, err := client.BuildUploadRequest(*HOSTNAME+"/upload_game", extraParams, "file", path)
-	if err != nil {
-		return err
-	}
-	resp, err := httpClient.Do(request)
-	if err != nil {
-		return err
-	}
-	body := &bytes.Buffer{}
-	_, err = body.ReadFrom(resp.Body)
-	if err != nil {
-		return err
-	}
-	resp.Body.Close()
-	fmt.Println(resp.StatusCode)
-	fmt.Println(resp.Header)
-	fmt.Println(body)
 
-	return nil
+	backoff := time.Second
+	for attempt := 0; attempt < 5; attempt++ {
+		request, err := client.BuildUploadRequest(*HOSTNAME+"/upload_game", extraParams, "file", path)
+		if err != nil {
+			return err
+		}
+		request = request.WithContext(ctx)
+
+		resp, err := httpClient.Do(request)
+		if err != nil {
+			return err
+		}
+		body := &bytes.Buffer{}
+		_, err = body.ReadFrom(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		if resp.StatusCode < http.StatusInternalServerError {
+			fmt.Println(resp.StatusCode)
+			fmt.Println(resp.Header)
+			fmt.Println(body)
+			if resp.StatusCode != http.StatusOK {
+				return fmt.Errorf("upload_game failed: %d %s", resp.StatusCode, body)
+			}
+			return nil
+		}
+
+		log.Printf("upload_game returned %d, retrying in %v", resp.StatusCode, backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff *= 2
+	}
+	return fmt.Errorf("upload_game: giving up after too many retries")
 }
 
 type CmdWrapper struct {
 	Cmd   *exec.Cmd
 	Pgn   string
 	Input io.WriteCloser
+	// Lines receives every line the engine prints on stdout outside a PGN
+	// block; the "PGN"/"END" markers and the lines between them go to Pgn
+	// instead. It is closed once the engine's stdout is closed (i.e. the
+	// process has exited or crashed).
+	Lines chan string
+	// done receives the result of Cmd.Wait(), run exactly once in a
+	// goroutine started by launch.
+	done chan error
 }
 
-func (c *CmdWrapper) openInput() {
+func (c *CmdWrapper) openInput() error {
+	if c.Input != nil {
+		return nil
+	}
 	var err error
 	c.Input, err = c.Cmd.StdinPipe()
-	if err != nil {
-		log.Fatal(err)
+	return err
+}
+
+// shutdown asks the engine to quit cleanly over stdin, giving it up to
+// grace to exit before killing it outright. Either way it blocks until
+// the process has actually been reaped, so callers never leak a zombie.
+func (c *CmdWrapper) shutdown(grace time.Duration) error {
+	if c.Input != nil {
+		c.sendCommand("quit")
+	}
+
+	select {
+	case err := <-c.done:
+		return err
+	case <-time.After(grace):
+		c.Cmd.Process.Kill()
+		return <-c.done
 	}
 }
 
-func (c *CmdWrapper) launch(networkPath string, args []string) {
+// sendCommand writes a single UCI command to the engine's stdin.
+func (c *CmdWrapper) sendCommand(cmd string) error {
+	_, err := io.WriteString(c.Input, cmd+"\n")
+	return err
+}
+
+// waitFor reads lines until match returns true, timeout elapses, ctx is
+// cancelled, or the engine exits. It returns the matching line, or an
+// error if any of those other cases happens first.
+func (c *CmdWrapper) waitFor(ctx context.Context, match func(string) bool, timeout time.Duration) (string, error) {
+	deadline := time.After(timeout)
+	for {
+		select {
+		case line, ok := <-c.Lines:
+			if !ok {
+				return "", errors.New("engine exited before responding")
+			}
+			if match(line) {
+				return line, nil
+			}
+		case <-deadline:
+			return "", fmt.Errorf("timed out after %v waiting for engine response", timeout)
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+}
+
+func (c *CmdWrapper) launch(networkPath string, args []string, gpu int) error {
 	var gpu_id string = ""
-	if *GPU != -1 {
-		gpu_id = fmt.Sprintf("--gpu=%v", *GPU)
+	if gpu != -1 {
+		gpu_id = fmt.Sprintf("--gpu=%v", gpu)
 	}
 	weights := fmt.Sprintf("--weights=%s", networkPath)
 	dir, _ := os.Getwd()
@@ -85,15 +199,17 @@ func (c *CmdWrapper) launch(networkPath string, args []string) {
 
 	stdout, err := c.Cmd.StdoutPipe()
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	stderr, err := c.Cmd.StderrPipe()
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
+	c.Lines = make(chan string, 256)
 	go func() {
+		defer close(c.Lines)
 		stdoutScanner := bufio.NewScanner(stdout)
 		reading_pgn := false
 		for stdoutScanner.Scan() {
@@ -105,6 +221,8 @@ func (c *CmdWrapper) launch(networkPath string, args []string) {
 				reading_pgn = false
 			} else if reading_pgn {
 				c.Pgn += line + "\n"
+			} else {
+				c.Lines <- line
 			}
 		}
 	}()
@@ -116,61 +234,212 @@ func (c *CmdWrapper) launch(networkPath string, args []string) {
 		}
 	}()
 
-	err = c.Cmd.Start()
-	if err != nil {
-		log.Fatal(err)
+	// stdin has to be piped before Start; exec refuses StdinPipe afterwards.
+	if err := c.openInput(); err != nil {
+		return err
+	}
+
+	if err := c.Cmd.Start(); err != nil {
+		return err
+	}
+
+	c.done = make(chan error, 1)
+	go func() {
+		c.done <- c.Cmd.Wait()
+	}()
+	return nil
+}
+
+// initEngine brings an engine up to the point where it's ready to play:
+// "uci" -> "uciok", "isready" -> "readyok", then "ucinewgame".
+func initEngine(ctx context.Context, c *CmdWrapper) error {
+	if err := c.sendCommand("uci"); err != nil {
+		return err
+	}
+	if _, err := c.waitFor(ctx, uci.IsUciOk, matchEngineTimeout); err != nil {
+		return fmt.Errorf("uci: %v", err)
+	}
+	if err := c.sendCommand("isready"); err != nil {
+		return err
+	}
+	if _, err := c.waitFor(ctx, uci.IsReadyOk, matchEngineTimeout); err != nil {
+		return fmt.Errorf("isready: %v", err)
 	}
+	return c.sendCommand("ucinewgame")
 }
 
-func playMatch(baselinePath string, candidatePath string, params []string, flip bool) {
+// playMatch drives baseline vs candidate over UCI for a single game and
+// reports the result (and winning side's PGN, if either engine emitted
+// one) to the server. flip swaps which engine plays white. If ctx is
+// cancelled mid-game, both engines are asked to quit (then killed after
+// shutdown-timeout) and the match is abandoned without reporting a result.
+func playMatch(ctx context.Context, httpClient *http.Client, matchGameId int64, baselinePath string, candidatePath string, params []string, flip bool) error {
 	baseline := CmdWrapper{}
-	baseline.launch(baselinePath, params)
-	baseline.openInput()
-	defer baseline.Input.Close()
+	if err := baseline.launch(baselinePath, params, *GPU); err != nil {
+		return fmt.Errorf("baseline: %v", err)
+	}
+	if err := baseline.openInput(); err != nil {
+		return fmt.Errorf("baseline: %v", err)
+	}
+	defer baseline.shutdown(*SHUTDOWN_TIMEOUT)
 
 	candidate := CmdWrapper{}
-	candidate.launch(candidatePath, params)
-	candidate.openInput()
-	defer candidate.Input.Close()
+	if err := candidate.launch(candidatePath, params, *GPU); err != nil {
+		return fmt.Errorf("candidate: %v", err)
+	}
+	if err := candidate.openInput(); err != nil {
+		return fmt.Errorf("candidate: %v", err)
+	}
+	defer candidate.shutdown(*SHUTDOWN_TIMEOUT)
 
-	p1 := &baseline
-	p2 := &candidate
+	if err := initEngine(ctx, &baseline); err != nil {
+		return fmt.Errorf("baseline: %v", err)
+	}
+	if err := initEngine(ctx, &candidate); err != nil {
+		return fmt.Errorf("candidate: %v", err)
+	}
 
+	white, black := &baseline, &candidate
+	candidateIsWhite := false
 	if flip {
-		p2, p1 = p1, p2
+		white, black = black, white
+		candidateIsWhite = true
 	}
 
-	// Play a game using UCI
-	is_white := true
-	for {
-		var p *CmdWrapper
-		if is_white {
-			p = p1
+	limits := uci.ParseGoLimits(params)
+	goCommand := limits.GoCommand()
+
+	var moves []string
+	result := uci.InProgress
+	toMove := white
+	for movesSinceStart := 0; result == uci.InProgress; movesSinceStart++ {
+		if uci.IsAdjudicatable(movesSinceStart, matchAdjudicationThreshold) {
+			result = uci.Draw
+			break
+		}
+
+		if err := toMove.sendCommand(uci.PositionCommand(moves)); err != nil {
+			return err
+		}
+		if err := toMove.sendCommand(goCommand); err != nil {
+			return err
+		}
+
+		line, err := toMove.waitFor(ctx, func(l string) bool {
+			_, _, ok := uci.ParseBestMove(l)
+			return ok
+		}, matchEngineTimeout)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			// A dead or hung engine forfeits the game.
+			if toMove == white {
+				result = uci.BlackWins
+			} else {
+				result = uci.WhiteWins
+			}
+			break
+		}
+
+		move, _, _ := uci.ParseBestMove(line)
+		if move == "(none)" || move == "0000" {
+			// No legal moves: checkmate or stalemate, and "bestmove
+			// (none)" alone can't tell us which. lc0 prints its own
+			// Result tag into the PGN once it recognises the game has
+			// ended, before reporting the (none) move, so trust that
+			// instead of assuming the side to move was mated.
+			pgn := white.Pgn
+			if pgn == "" {
+				pgn = black.Pgn
+			}
+			if r, ok := uci.ResultFromPGN(pgn); ok {
+				result = r
+			} else if toMove == white {
+				result = uci.BlackWins
+			} else {
+				result = uci.WhiteWins
+			}
+			break
+		}
+		moves = append(moves, move)
+
+		if toMove == white {
+			toMove = black
 		} else {
-			p = p2
+			toMove = white
 		}
-		p.Input.WriteString()
 	}
+
+	// lczero prints a PGN once it detects the game is over. Upload the
+	// winning side's; for a draw, or if the winner printed none, fall
+	// back to whichever engine has one, candidate first.
+	pgn := ""
+	switch result {
+	case uci.WhiteWins:
+		pgn = white.Pgn
+	case uci.BlackWins:
+		pgn = black.Pgn
+	}
+	if pgn == "" {
+		pgn = candidate.Pgn
+	}
+	if pgn == "" {
+		pgn = baseline.Pgn
+	}
+
+	return postMatchResult(ctx, httpClient, matchGameId, result.ServerResult(candidateIsWhite), pgn)
+}
+
+// postMatchResult reports a finished match game back to the server.
+func postMatchResult(ctx context.Context, httpClient *http.Client, matchGameId int64, result int, pgn string) error {
+	extraParams := getExtraParams()
+	extraParams["match_game_id"] = strconv.FormatInt(matchGameId, 10)
+	extraParams["result"] = strconv.Itoa(result)
+	extraParams["pgn"] = pgn
+
+	data := url.Values{}
+	for key, val := range extraParams {
+		data.Set(key, val)
+	}
+	request, err := http.NewRequestWithContext(ctx, "POST", *HOSTNAME+"/match_result", strings.NewReader(data.Encode()))
+	if err != nil {
+		return err
+	}
+	request.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("match_result upload failed: %d %s", resp.StatusCode, body)
+	}
+	return nil
 }
 
-func train(networkPath string, params []string) (string, string) {
-	// pid is intended for use in multi-threaded training
+// train runs a single training game for worker workerID on the given GPU,
+// returning the path to the resulting training data file and the game's
+// PGN. Each worker gets its own data dir (keyed by pid and workerID) so
+// concurrent workers in the same process never collide.
+func train(ctx context.Context, workerID int, gpu int, networkPath string, params []string) (string, string, error) {
 	pid := os.Getpid()
 
 	dir, _ := os.Getwd()
-	train_dir := path.Join(dir, fmt.Sprintf("data-%v", pid))
+	train_dir := path.Join(dir, fmt.Sprintf("data-%v-%v", pid, workerID))
 	if _, err := os.Stat(train_dir); err == nil {
 		files, err := ioutil.ReadDir(train_dir)
 		if err != nil {
-			log.Fatal(err)
+			return "", "", err
 		}
 		fmt.Printf("Cleanup training files:\n")
 		for _, f := range files {
 			fmt.Printf("%s/%s\n", train_dir, f.Name())
 		}
-		err = os.RemoveAll(train_dir)
-		if err != nil {
-			log.Fatal(err)
+		if err := os.RemoveAll(train_dir); err != nil {
+			return "", "", err
 		}
 	}
 
@@ -179,17 +448,37 @@ func train(networkPath string, params []string) (string, string) {
 	params = append(params, train_cmd)
 
 	c := CmdWrapper{}
-	c.launch(networkPath, params)
-
-	err := c.Cmd.Wait()
-	if err != nil {
-		log.Fatal(err)
+	if err := c.launch(networkPath, params, gpu); err != nil {
+		return "", "", err
 	}
 
-	return path.Join(train_dir, "training.0.gz"), c.Pgn
+	trainFile := path.Join(train_dir, "training.0.gz")
+	select {
+	case err := <-c.done:
+		if err != nil {
+			return "", "", err
+		}
+		return trainFile, c.Pgn, nil
+	case <-ctx.Done():
+		// Let the engine finish writing out the game it's mid-way
+		// through rather than yanking the rug out from under it:
+		// wait out the grace period before telling it to quit, since
+		// shutdown() itself sends quit immediately and would cut the
+		// game short the instant we called it here.
+		select {
+		case err := <-c.done:
+			if err != nil {
+				return "", "", err
+			}
+			return trainFile, c.Pgn, nil
+		case <-time.After(*SHUTDOWN_TIMEOUT):
+		}
+		c.shutdown(0)
+		return trainFile, c.Pgn, ctx.Err()
+	}
 }
 
-func getNetwork(httpClient *http.Client, sha string, clearOld bool) (string, error) {
+func getNetwork(ctx context.Context, httpClient *http.Client, sha string, clearOld bool) (string, error) {
 	// Sha already exists?
 	path := filepath.Join("networks", sha)
 	if _, err := os.Stat(path); err == nil {
@@ -197,48 +486,172 @@ func getNetwork(httpClient *http.Client, sha string, clearOld bool) (string, err
 	}
 
 	if clearOld {
-		// Clean out any old networks
-		os.RemoveAll("networks")
+		// Clean out old networks, but keep sha's own in-progress .part
+		// file (if a previous run got interrupted partway through
+		// fetching it), so the resumable-download path actually gets to
+		// resume it instead of always restarting training downloads from
+		// scratch.
+		partName := sha + client.PartSuffix
+		if entries, err := ioutil.ReadDir("networks"); err == nil {
+			for _, entry := range entries {
+				if entry.Name() == partName {
+					continue
+				}
+				os.RemoveAll(filepath.Join("networks", entry.Name()))
+			}
+		}
 	}
 	os.MkdirAll("networks", os.ModePerm)
 
 	// Otherwise, let's download it
-	err := client.DownloadNetwork(httpClient, *HOSTNAME, path, sha)
+	err := client.DownloadNetwork(ctx, httpClient, *HOSTNAME, path, sha)
 	if err != nil {
 		return "", err
 	}
 	return path, nil
 }
 
-func nextGame(httpClient *http.Client) error {
-	nextGame, err := client.NextGame(httpClient, *HOSTNAME, getExtraParams())
+// getNetworks fetches every sha in shas into the "networks" dir, using
+// DownloadBundle to pull whichever ones are missing in parallel rather
+// than one at a time -- used for match games, which need both the
+// baseline and candidate network before they can start.
+func getNetworks(ctx context.Context, httpClient *http.Client, shas []string) (map[string]string, error) {
+	os.MkdirAll("networks", os.ModePerm)
+
+	paths := make(map[string]string, len(shas))
+	var missing []string
+	for _, sha := range shas {
+		path := filepath.Join("networks", sha)
+		if _, err := os.Stat(path); err == nil {
+			paths[sha] = path
+		} else {
+			missing = append(missing, sha)
+		}
+	}
+
+	if len(missing) > 0 {
+		if err := client.DownloadBundle(ctx, httpClient, *HOSTNAME, "networks", missing, len(missing)); err != nil {
+			return nil, err
+		}
+		for _, sha := range missing {
+			paths[sha] = filepath.Join("networks", sha)
+		}
+	}
+	return paths, nil
+}
+
+// nextGame asks the server for one game to play and runs it. workerID and
+// gpu identify which training worker is calling (match games always run
+// on the single global --gpu, whichever worker picks them up); train
+// games hand their finished data off to uploads instead of uploading
+// inline, so a slow server doesn't stall the worker that produced it.
+func nextGame(ctx context.Context, httpClient *http.Client, workerID int, gpu int, uploads chan<- uploadJob) error {
+	nextGame, err := client.NextGame(ctx, httpClient, *HOSTNAME, getExtraParams())
 	if err != nil {
 		return err
 	}
 	if nextGame.Type == "match" {
-		networkPath, err := getNetwork(httpClient, nextGame.Sha, false)
+		// A match needs both networks before it can start, so fetch
+		// whichever of the two aren't already on disk in parallel via
+		// the bundle endpoint instead of one at a time.
+		paths, err := getNetworks(ctx, httpClient, []string{nextGame.Sha, nextGame.CandidateSha})
 		if err != nil {
 			return err
 		}
-		candidatePath, err := getNetwork(httpClient, nextGame.CandidateSha, false)
+		return playMatch(ctx, httpClient, nextGame.MatchGameId, paths[nextGame.Sha], paths[nextGame.CandidateSha], nextGame.Params, nextGame.Flip)
+	} else if nextGame.Type == "train" {
+		// getNetwork(clearOld=true) also means each worker naturally picks
+		// up a promoted best network on its next call, without any extra
+		// signalling needed.
+		networkPath, err := getNetwork(ctx, httpClient, nextGame.Sha, true)
 		if err != nil {
 			return err
 		}
-		playMatch(networkPath, candidatePath, nextGame.Params, nextGame.Flip)
-		return nil
-	} else if nextGame.Type == "train" {
-		networkPath, err := getNetwork(httpClient, nextGame.Sha, true)
+		trainFile, pgn, err := train(ctx, workerID, gpu, networkPath, nextGame.Params)
 		if err != nil {
+			// A shutdown mid-game (or any other failure) leaves trainFile
+			// truncated or missing, so only completed games are handed
+			// to the uploader.
 			return err
 		}
-		trainFile, pgn := train(networkPath, nextGame.Params)
-		uploadGame(httpClient, trainFile, pgn, nextGame)
+		uploads <- uploadJob{path: trainFile, pgn: pgn, nextGame: nextGame}
 		return nil
 	}
 
 	return errors.New("Unknown game type: " + nextGame.Type)
 }
 
+// gpuForWorker picks which OpenCL device workerID should use: round-robin
+// over --gpus if it was given, otherwise the single --gpu flag for every
+// worker.
+func gpuForWorker(workerID int) int {
+	if *GPUS == "" {
+		return *GPU
+	}
+	ids := strings.Split(*GPUS, ",")
+	id := strings.TrimSpace(ids[workerID%len(ids)])
+	gpu, err := strconv.Atoi(id)
+	if err != nil {
+		return *GPU
+	}
+	return gpu
+}
+
+// runWorker repeatedly plays games until ctx is cancelled, backing off
+// for 30s between games that fail outright.
+func runWorker(ctx context.Context, workerID int, httpClient *http.Client, uploads chan<- uploadJob) {
+	gpu := gpuForWorker(workerID)
+	for ctx.Err() == nil {
+		if err := nextGame(ctx, httpClient, workerID, gpu, uploads); err != nil {
+			log.Printf("worker %d: %v", workerID, err)
+			if ctx.Err() != nil {
+				return
+			}
+			select {
+			case <-time.After(30 * time.Second):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// superviseWorker runs runWorker and restarts it if it panics, so one bad
+// game doesn't take down the whole worker pool.
+func superviseWorker(ctx context.Context, workerID int, httpClient *http.Client, uploads chan<- uploadJob) {
+	for ctx.Err() == nil {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Printf("worker %d crashed (%v), restarting", workerID, r)
+				}
+			}()
+			runWorker(ctx, workerID, httpClient, uploads)
+		}()
+	}
+}
+
+// pingShutdown lets the server know this worker is going away, best
+// effort, so it isn't left thinking the worker is still claiming games.
+func pingShutdown(httpClient *http.Client, hostname string) {
+	request, err := http.NewRequest("POST", hostname+"/client_shutdown", strings.NewReader(url.Values{
+		"user": {*USER},
+	}.Encode()))
+	if err != nil {
+		return
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	resp, err := httpClient.Do(request.WithContext(ctx))
+	if err != nil {
+		log.Printf("Failed to notify server of shutdown: %v", err)
+		return
+	}
+	resp.Body.Close()
+}
+
 func main() {
 	flag.Parse()
 	if len(*USER) == 0 {
@@ -248,14 +661,39 @@ func main() {
 		log.Fatal("You must specify a non-empty password")
 	}
 
+	if err := client.VerifyNetworks("networks"); err != nil {
+		log.Printf("Failed to verify existing networks: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	defer stop()
+
 	httpClient := &http.Client{}
-	for {
-		err := nextGame(httpClient)
-		if err != nil {
-			log.Print(err)
-			log.Print("Sleeping for 30 seconds...")
-			time.Sleep(30 * time.Second)
-			continue
-		}
+
+	uploads := make(chan uploadJob)
+	var uploaderDone sync.WaitGroup
+	uploaderDone.Add(1)
+	go func() {
+		defer uploaderDone.Done()
+		runUploader(context.Background(), httpClient, uploads)
+	}()
+
+	var workers sync.WaitGroup
+	for i := 0; i < *WORKERS; i++ {
+		workers.Add(1)
+		go func(workerID int) {
+			defer workers.Done()
+			superviseWorker(ctx, workerID, httpClient, uploads)
+		}(i)
 	}
+	workers.Wait()
+
+	// Every worker has stopped claiming new games; once the uploader has
+	// drained whatever they handed off, it's safe to tell the server we're
+	// going away.
+	close(uploads)
+	uploaderDone.Wait()
+
+	log.Print("Shutting down...")
+	pingShutdown(httpClient, *HOSTNAME)
 }
@@ -0,0 +1,194 @@
+// Package uci implements just enough of the Universal Chess Interface to
+// drive two engines through a game: building the "go" command from the
+// match parameters the server hands out, and parsing the handful of
+// response lines (bestmove/readyok/uciok) that the driver cares about.
+// It is shared between training (self-play) and match-play so both modes
+// talk to lczero the same way.
+package uci
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// GoLimits captures the search limit the server asked for in a match's
+// parameter list, e.g. `["--visits 800"]` or `["--movetime 100"]`.
+type GoLimits struct {
+	MoveTime int // milliseconds
+	Nodes    int
+	Visits   int
+}
+
+// ParseGoLimits scans the free-form match parameters for the limit flags
+// lczero understands. Unrecognised tokens are ignored so extra engine
+// flags (e.g. "--noise") can ride along in the same slice.
+func ParseGoLimits(params []string) GoLimits {
+	var limits GoLimits
+	for _, param := range params {
+		fields := strings.Fields(param)
+		for i := 0; i < len(fields); i++ {
+			flag := strings.TrimLeft(fields[i], "-")
+			var value string
+			if eq := strings.IndexByte(flag, '='); eq != -1 {
+				value = flag[eq+1:]
+				flag = flag[:eq]
+			} else if i+1 < len(fields) {
+				value = fields[i+1]
+				i++
+			} else {
+				continue
+			}
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				continue
+			}
+			switch flag {
+			case "movetime":
+				limits.MoveTime = n
+			case "nodes":
+				limits.Nodes = n
+			case "visits":
+				limits.Visits = n
+			}
+		}
+	}
+	return limits
+}
+
+// GoCommand builds the "go" command to send for the given limits. It
+// defaults to "go infinite" only as a last resort -- callers should always
+// enforce their own wall-clock timeout regardless of what is sent here.
+func (l GoLimits) GoCommand() string {
+	switch {
+	case l.MoveTime > 0:
+		return fmt.Sprintf("go movetime %d", l.MoveTime)
+	case l.Nodes > 0:
+		return fmt.Sprintf("go nodes %d", l.Nodes)
+	case l.Visits > 0:
+		return fmt.Sprintf("go visits %d", l.Visits)
+	default:
+		return "go infinite"
+	}
+}
+
+// PositionCommand builds the "position startpos moves ..." command for the
+// moves played so far.
+func PositionCommand(moves []string) string {
+	if len(moves) == 0 {
+		return "position startpos"
+	}
+	return "position startpos moves " + strings.Join(moves, " ")
+}
+
+// ParseBestMove parses a "bestmove <move> [ponder <move>]" line. ok is
+// false if the line isn't a bestmove line at all.
+func ParseBestMove(line string) (move string, ponder string, ok bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 || fields[0] != "bestmove" {
+		return "", "", false
+	}
+	move = fields[1]
+	if len(fields) >= 4 && fields[2] == "ponder" {
+		ponder = fields[3]
+	}
+	return move, ponder, true
+}
+
+// IsReadyOk reports whether line is the engine's response to "isready".
+func IsReadyOk(line string) bool {
+	return strings.TrimSpace(line) == "readyok"
+}
+
+// IsUciOk reports whether line is the engine's response to "uci".
+func IsUciOk(line string) bool {
+	return strings.TrimSpace(line) == "uciok"
+}
+
+// Result is the outcome of a finished game, from the perspective of the
+// PGN's "Result" tag.
+type Result int
+
+const (
+	// InProgress means the game has not ended yet.
+	InProgress Result = iota
+	WhiteWins
+	BlackWins
+	Draw
+)
+
+// String returns the PGN result tag, e.g. "1-0", "0-1" or "1/2-1/2".
+func (r Result) String() string {
+	switch r {
+	case WhiteWins:
+		return "1-0"
+	case BlackWins:
+		return "0-1"
+	case Draw:
+		return "1/2-1/2"
+	default:
+		return "*"
+	}
+}
+
+// ServerResult maps a Result to the integer the training server expects in
+// /match_result's "result" field: 1 if the candidate (white when
+// candidateIsWhite, otherwise black) won, -1 if it lost, 0 for a draw.
+func (r Result) ServerResult(candidateIsWhite bool) int {
+	switch r {
+	case WhiteWins:
+		if candidateIsWhite {
+			return 1
+		}
+		return -1
+	case BlackWins:
+		if candidateIsWhite {
+			return -1
+		}
+		return 1
+	default:
+		return 0
+	}
+}
+
+// ParseResult parses a PGN result token ("1-0", "0-1", "1/2-1/2") into a
+// Result. ok is false for "*" or anything else unrecognised.
+func ParseResult(s string) (Result, bool) {
+	switch s {
+	case "1-0":
+		return WhiteWins, true
+	case "0-1":
+		return BlackWins, true
+	case "1/2-1/2":
+		return Draw, true
+	default:
+		return InProgress, false
+	}
+}
+
+// ResultFromPGN extracts the `[Result "..."]` tag lc0 itself writes into
+// the PGN once it detects the game has ended. lc0 distinguishes
+// checkmate from stalemate (and other draw rules) when it emits this tag,
+// so callers should prefer it over guessing a result from "bestmove
+// (none)" alone, which only signals "no legal move" either way.
+func ResultFromPGN(pgn string) (Result, bool) {
+	const tag = `[Result "`
+	i := strings.Index(pgn, tag)
+	if i == -1 {
+		return InProgress, false
+	}
+	rest := pgn[i+len(tag):]
+	j := strings.IndexByte(rest, '"')
+	if j == -1 {
+		return InProgress, false
+	}
+	return ParseResult(rest[:j])
+}
+
+// IsAdjudicatable reports whether movesSinceProgress has reached threshold,
+// at which point the game should be adjudicated a draw. A threshold of zero
+// or less never adjudicates. It does not look at the moves themselves:
+// what the count measures is up to the caller.
+func IsAdjudicatable(movesSinceProgress, threshold int) bool {
+	return threshold > 0 && movesSinceProgress >= threshold
+}
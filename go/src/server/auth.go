@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"time"
+
+	"server/db"
+)
+
+// authCacheTTL is short because, unlike the best-network cache, a stale
+// hit here would let a password change keep working briefly -- fine for
+// this threat model, but not worth risking for more than a few seconds.
+const authCacheTTL = 10 * time.Second
+
+func authCacheKey(username, password string) string {
+	sum := sha256.Sum256([]byte(username + "\x00" + password))
+	return fmt.Sprintf("auth:%x", sum)
+}
+
+// cachedAuthenticate checks username/password against the cache before
+// falling back to a DB lookup, since /next_game re-authenticates on
+// every single request a client makes.
+func cachedAuthenticate(username, password string) (uint, error) {
+	key := authCacheKey(username, password)
+
+	var userID uint
+	if hit, err := netCache.Get(context.Background(), key, &userID); err == nil && hit {
+		return userID, nil
+	}
+
+	var user db.User
+	if err := db.GetDB().Where("username = ? AND password = ?", username, password).First(&user).Error; err != nil {
+		return 0, err
+	}
+
+	netCache.Set(context.Background(), key, user.ID, authCacheTTL)
+	return user.ID, nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"server/db"
+)
+
+const bestNetworkTTL = time.Minute
+
+func bestNetworkCacheKey(trainingRunID uint) string {
+	return fmt.Sprintf("best_network:%d", trainingRunID)
+}
+
+// cachedBestNetworkID returns the network ID /next_game should hand out
+// for trainingRunID, checking the cache before GORM since this lookup
+// happens on every /next_game request.
+func cachedBestNetworkID(trainingRunID uint) (uint, error) {
+	key := bestNetworkCacheKey(trainingRunID)
+
+	var networkID uint
+	if hit, err := netCache.Get(context.Background(), key, &networkID); err == nil && hit {
+		return networkID, nil
+	}
+
+	var run db.TrainingRun
+	if err := db.GetDB().First(&run, trainingRunID).Error; err != nil {
+		return 0, err
+	}
+
+	netCache.Set(context.Background(), key, run.BestNetworkID, bestNetworkTTL)
+	return run.BestNetworkID, nil
+}
+
+// invalidateBestNetwork drops the cached best network for trainingRunID.
+// Callers promoting a new best network (e.g. after a match settles, or
+// on /upload_network) must call this or clients will keep training
+// against the stale network until the TTL expires.
+func invalidateBestNetwork(trainingRunID uint) {
+	netCache.Delete(context.Background(), bestNetworkCacheKey(trainingRunID))
+}
@@ -0,0 +1,176 @@
+// Package cache memoizes read-heavy, rarely-changing lookups the server
+// would otherwise repeat on every request: network blobs, the current
+// best network for a training run, and per-request auth checks. It
+// prefers Redis (so a multi-instance deployment shares one cache) and
+// transparently falls back to an in-process store when no Redis address
+// is configured, so a single-instance or test deployment needs nothing
+// extra running.
+package cache
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/go-redis/cache/v8"
+	"github.com/go-redis/redis/v8"
+)
+
+// Cache is the subset of behavior the server needs: get/set a value by
+// key with a TTL, and delete a key outright for invalidation.
+type Cache interface {
+	// Get looks up key and, if present, unmarshals it into dest (which
+	// must be a pointer). It reports whether the key was found.
+	Get(ctx context.Context, key string, dest interface{}) (bool, error)
+	Set(ctx context.Context, key string, value interface{}, ttl time.Duration) error
+	Delete(ctx context.Context, key string) error
+}
+
+// New returns a Redis-backed Cache talking to addr, or an in-process
+// cache if addr is empty.
+func New(addr string) Cache {
+	if addr == "" {
+		return newLocalCache()
+	}
+	return newRedisCache(addr)
+}
+
+type redisCache struct {
+	codec *cache.Cache
+}
+
+func newRedisCache(addr string) *redisCache {
+	ring := redis.NewClient(&redis.Options{Addr: addr})
+	return &redisCache{codec: cache.New(&cache.Options{Redis: ring})}
+}
+
+func (r *redisCache) Get(ctx context.Context, key string, dest interface{}) (bool, error) {
+	if err := r.codec.Get(ctx, key, dest); err != nil {
+		if err == cache.ErrCacheMiss {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (r *redisCache) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	return r.codec.Set(&cache.Item{
+		Ctx:   ctx,
+		Key:   key,
+		Value: value,
+		TTL:   ttl,
+	})
+}
+
+func (r *redisCache) Delete(ctx context.Context, key string) error {
+	return r.codec.Delete(ctx, key)
+}
+
+// localCacheCapacity bounds how many entries localCache holds at once.
+// Without a cap, caching a growing set of network blobs with a 24h TTL
+// on a single long-lived instance would otherwise grow the in-process
+// map without bound between evictions.
+const localCacheCapacity = 1024
+
+// localCache is a minimal in-process TTL cache used when no Redis
+// address is configured, so a single-instance deployment (and
+// StoreSuite, which never sets --redis) still benefits from caching
+// without needing anything external running. It's bounded by
+// localCacheCapacity and evicts the least recently used entry once full,
+// on top of the usual TTL expiry.
+type localCache struct {
+	mu       sync.Mutex
+	capacity int
+	entries  map[string]*list.Element
+	order    *list.List // front = most recently used
+}
+
+type localEntry struct {
+	key     string
+	value   interface{}
+	expires time.Time
+}
+
+func newLocalCache() *localCache {
+	return &localCache{
+		capacity: localCacheCapacity,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+func (l *localCache) Get(ctx context.Context, key string, dest interface{}) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	elem, ok := l.entries[key]
+	if !ok {
+		return false, nil
+	}
+	entry := elem.Value.(*localEntry)
+	if time.Now().After(entry.expires) {
+		l.removeElement(elem)
+		return false, nil
+	}
+	l.order.MoveToFront(elem)
+
+	// dest is always a pointer to the same type Set was called with for
+	// this key, since callers use one key namespace per value type.
+	switch d := dest.(type) {
+	case *string:
+		*d = entry.value.(string)
+	case *uint:
+		*d = entry.value.(uint)
+	case *[]byte:
+		*d = entry.value.([]byte)
+	default:
+		return false, errUnsupportedType
+	}
+	return true, nil
+}
+
+// errUnsupportedType is returned by localCache.Get for a dest type no
+// caller actually uses; the Redis-backed implementation has no such
+// restriction since it round-trips through msgpack instead of a type
+// switch.
+var errUnsupportedType = errors.New("cache: unsupported dest type for local cache")
+
+func (l *localCache) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	expires := time.Now().Add(ttl)
+	if elem, ok := l.entries[key]; ok {
+		elem.Value.(*localEntry).value = value
+		elem.Value.(*localEntry).expires = expires
+		l.order.MoveToFront(elem)
+		return nil
+	}
+
+	elem := l.order.PushFront(&localEntry{key: key, value: value, expires: expires})
+	l.entries[key] = elem
+
+	if l.order.Len() > l.capacity {
+		l.removeElement(l.order.Back())
+	}
+	return nil
+}
+
+func (l *localCache) Delete(ctx context.Context, key string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if elem, ok := l.entries[key]; ok {
+		l.removeElement(elem)
+	}
+	return nil
+}
+
+// removeElement drops elem from both the LRU list and the lookup map.
+// Callers must hold l.mu.
+func (l *localCache) removeElement(elem *list.Element) {
+	l.order.Remove(elem)
+	delete(l.entries, elem.Value.(*localEntry).key)
+}
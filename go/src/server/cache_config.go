@@ -0,0 +1,24 @@
+package main
+
+import (
+	"flag"
+
+	"server/cache"
+)
+
+// RedisAddr points the server at a Redis instance to share its cache
+// across multiple server instances. When empty, InitCache falls back to
+// an in-process cache, which is what StoreSuite runs against.
+var RedisAddr = flag.String("redis", "", "Redis address (host:port) to use for caching; falls back to an in-process cache when empty")
+
+// netCache backs the best-network, auth, and network-blob caches below.
+// It defaults to the in-process fallback so tests (which never call
+// InitCache) still get a working cache; InitCache swaps in Redis once
+// --redis has actually been parsed.
+var netCache cache.Cache = cache.New("")
+
+// InitCache must be called once after flag.Parse(), before the router
+// starts serving requests, to pick up a --redis address if one was given.
+func InitCache() {
+	netCache = cache.New(*RedisAddr)
+}
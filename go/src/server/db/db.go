@@ -0,0 +1,109 @@
+// Package db defines the training server's schema and owns the single
+// *gorm.DB connection every handler queries through.
+package db
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+// User is a training client's login. Games and match results uploaded
+// under a username are attributed back to this record.
+type User struct {
+	ID       uint `gorm:"primary_key"`
+	Username string `gorm:"unique_index"`
+	Password string
+}
+
+// Network is one trained set of weights, addressed by the sha256 of its
+// decompressed weights; the file at Path holds the gzip-compressed upload.
+type Network struct {
+	ID          uint `gorm:"primary_key"`
+	Sha         string `gorm:"unique_index"`
+	Path        string
+	Layers      int
+	Filters     int
+	GamesPlayed int
+}
+
+// TrainingRun groups the games and networks produced by one training
+// session and tracks which network is currently considered best.
+type TrainingRun struct {
+	ID            uint `gorm:"primary_key"`
+	Description   string
+	BestNetworkID uint
+	BestNetwork   Network
+	Active        bool
+}
+
+// Match pits a candidate network against a training run's current best
+// over a fixed number of games, to decide whether it should be promoted.
+// CandidateID/CurrentBestID are plain foreign keys rather than gorm
+// associations, since callers always look the networks up explicitly --
+// embedding the struct here would make gorm try to save a blank Network
+// on every Match insert that doesn't set it.
+type Match struct {
+	ID            uint `gorm:"primary_key"`
+	TrainingRunID uint
+	Parameters    string
+	CandidateID   uint
+	CurrentBestID uint
+	Done          bool
+}
+
+// MatchGame is a single game played as part of a Match.
+type MatchGame struct {
+	ID      uint `gorm:"primary_key"`
+	MatchID uint
+	UserID  uint
+	Result  int
+	Pgn     string
+	Done    bool
+}
+
+// TrainingGame is a single self-play game uploaded as training data.
+// CreatedAt is populated automatically by GORM and is what the
+// /metrics "active users" gauge uses to tell recent activity from a
+// user who uploaded a game once months ago.
+type TrainingGame struct {
+	ID            uint `gorm:"primary_key"`
+	UserID        uint
+	TrainingRunID uint
+	NetworkID     uint
+	Path          string
+	Version       string
+	CreatedAt     time.Time
+}
+
+var conn *gorm.DB
+
+// Init opens the database connection. verbose turns on GORM's query
+// logging, which tests leave off since it drowns out test failures.
+func Init(verbose bool) {
+	var err error
+	conn, err = gorm.Open("sqlite3", "file::memory:?cache=shared")
+	if err != nil {
+		panic(fmt.Sprintf("failed to open database: %v", err))
+	}
+	conn.LogMode(verbose)
+	SetupDB()
+}
+
+// GetDB returns the shared database handle opened by Init.
+func GetDB() *gorm.DB {
+	return conn
+}
+
+// SetupDB (re)creates every table this package defines. Tests call this
+// after dropping them to get back to a clean schema.
+func SetupDB() {
+	conn.AutoMigrate(&User{}, &Network{}, &TrainingRun{}, &Match{}, &MatchGame{}, &TrainingGame{})
+}
+
+// Close releases the database connection.
+func Close() {
+	conn.Close()
+}
@@ -0,0 +1,15 @@
+package main
+
+import (
+	"github.com/jinzhu/gorm"
+
+	"server/db"
+)
+
+// incrementGamesPlayed bumps a network's games-played counter by one at
+// the database level, so concurrent /upload_game requests for the same
+// network can't race each other the way a read-then-write from Go would.
+func incrementGamesPlayed(networkID uint) error {
+	return db.GetDB().Model(&db.Network{}).Where("id = ?", networkID).
+		Update("games_played", gorm.Expr("games_played + ?", 1)).Error
+}
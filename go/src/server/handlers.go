@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"server/db"
+	"server/metrics"
+)
+
+// nextGameResponse is the JSON body returned by POST /next_game. Fields
+// specific to one game type are omitempty so the response only contains
+// the keys that type actually uses.
+type nextGameResponse struct {
+	Type         string `json:"type"`
+	TrainingId   uint   `json:"trainingId,omitempty"`
+	NetworkId    uint   `json:"networkId,omitempty"`
+	Sha          string `json:"sha"`
+	CandidateSha string `json:"candidateSha,omitempty"`
+	MatchGameId  uint   `json:"matchGameId,omitempty"`
+	Params       string `json:"params"`
+}
+
+// nextGameHandler hands out either a match game (if the requesting user
+// is authenticated and a match is waiting) or a training game against
+// the active run's current best network.
+func nextGameHandler(c *gin.Context) {
+	gameType := "train"
+	start := time.Now()
+	defer func() {
+		metrics.NextGameLatency.WithLabelValues(gameType).Observe(time.Since(start).Seconds())
+	}()
+
+	var userID uint
+	authenticated := false
+	if username := c.PostForm("user"); username != "" {
+		password := c.PostForm("password")
+		id, err := cachedAuthenticate(username, password)
+		if err != nil {
+			// Register an unknown username on first contact, as
+			// /upload_game does; a known username with the wrong
+			// password stays unauthenticated.
+			var existing db.User
+			if db.GetDB().Where("username = ?", username).First(&existing).Error != nil {
+				user := db.User{Username: username, Password: password}
+				if db.GetDB().Create(&user).Error == nil {
+					id, err = user.ID, nil
+				}
+			}
+		}
+		if err == nil {
+			userID = id
+			authenticated = true
+		}
+	}
+
+	var run db.TrainingRun
+	if err := db.GetDB().Where("active = ?", true).First(&run).Error; err != nil {
+		c.String(http.StatusInternalServerError, "No active training run")
+		return
+	}
+
+	bestNetworkID, err := cachedBestNetworkID(run.ID)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "%v", err)
+		return
+	}
+	var bestNetwork db.Network
+	if err := db.GetDB().First(&bestNetwork, bestNetworkID).Error; err != nil {
+		c.String(http.StatusInternalServerError, "%v", err)
+		return
+	}
+
+	if authenticated {
+		var match db.Match
+		err := db.GetDB().Where("training_run_id = ? AND done = ?", run.ID, false).First(&match).Error
+		if err == nil {
+			var candidate db.Network
+			if err := db.GetDB().First(&candidate, match.CandidateID).Error; err == nil {
+				matchGame := db.MatchGame{MatchID: match.ID, UserID: userID}
+				if err := db.GetDB().Create(&matchGame).Error; err != nil {
+					c.String(http.StatusInternalServerError, "%v", err)
+					return
+				}
+
+				gameType = "match"
+				c.JSON(http.StatusOK, nextGameResponse{
+					Type:         "match",
+					MatchGameId:  matchGame.ID,
+					Sha:          bestNetwork.Sha,
+					CandidateSha: candidate.Sha,
+					Params:       match.Parameters,
+				})
+				return
+			}
+		}
+	}
+
+	c.JSON(http.StatusOK, nextGameResponse{
+		Type:       "train",
+		TrainingId: run.ID,
+		NetworkId:  bestNetwork.ID,
+		Sha:        bestNetwork.Sha,
+	})
+}
+
+// uploadGameHandler records a finished training game, registering the
+// uploading user on first contact.
+func uploadGameHandler(c *gin.Context) {
+	username := c.PostForm("user")
+
+	var user db.User
+	if err := db.GetDB().Where("username = ?", username).First(&user).Error; err != nil {
+		user = db.User{Username: username, Password: c.PostForm("password")}
+		if err := db.GetDB().Create(&user).Error; err != nil {
+			c.String(http.StatusInternalServerError, "%v", err)
+			return
+		}
+	}
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		c.String(http.StatusBadRequest, "Missing file: %v", err)
+		return
+	}
+	src, err := fileHeader.Open()
+	if err != nil {
+		c.String(http.StatusInternalServerError, "%v", err)
+		return
+	}
+	defer src.Close()
+
+	os.MkdirAll("games", os.ModePerm)
+	path := filepath.Join("games", fmt.Sprintf("%d-%d.gz", user.ID, time.Now().UnixNano()))
+	out, err := os.Create(path)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "%v", err)
+		return
+	}
+	size, err := io.Copy(out, src)
+	out.Close()
+	if err != nil {
+		c.String(http.StatusInternalServerError, "%v", err)
+		return
+	}
+	metrics.UploadBytes.Observe(float64(size))
+
+	trainingRunID, _ := strconv.ParseUint(c.PostForm("training_id"), 10, 64)
+	networkID, _ := strconv.ParseUint(c.PostForm("network_id"), 10, 64)
+
+	// Use a DB-level atomic increment rather than read-modify-write, since
+	// concurrent uploads for the same network (now routine now that the
+	// client runs several training workers at once) would otherwise race
+	// and drop counts.
+	if err := incrementGamesPlayed(uint(networkID)); err != nil {
+		c.String(http.StatusInternalServerError, "%v", err)
+		return
+	}
+
+	game := db.TrainingGame{
+		UserID:        user.ID,
+		TrainingRunID: uint(trainingRunID),
+		NetworkID:     uint(networkID),
+		Path:          path,
+		Version:       c.PostForm("version"),
+	}
+	if err := db.GetDB().Create(&game).Error; err != nil {
+		c.String(http.StatusInternalServerError, "%v", err)
+		return
+	}
+
+	metrics.GamesUploaded.WithLabelValues(c.PostForm("training_id"), username).Inc()
+	c.String(http.StatusOK, "ok")
+}
+
+// uploadNetworkHandler stores a newly trained network and immediately
+// promotes it to best for its training run; match verification against
+// the previous best happens out of band, not in this request.
+func uploadNetworkHandler(c *gin.Context) {
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		c.String(http.StatusBadRequest, "Missing file: %v", err)
+		return
+	}
+	src, err := fileHeader.Open()
+	if err != nil {
+		c.String(http.StatusInternalServerError, "%v", err)
+		return
+	}
+	defer src.Close()
+
+	var compressed bytes.Buffer
+	size, err := io.Copy(&compressed, src)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "%v", err)
+		return
+	}
+	metrics.UploadBytes.Observe(float64(size))
+
+	gz, err := gzip.NewReader(bytes.NewReader(compressed.Bytes()))
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid gzip: %v", err)
+		return
+	}
+	defer gz.Close()
+
+	// Cap how much decompressed data we'll hash, so a maliciously crafted
+	// gzip bomb can't be used to exhaust server memory/CPU just to
+	// compute a sha256.
+	hash := sha256.New()
+	if _, err := io.Copy(hash, limitedNetworkReader(gz)); err != nil {
+		c.String(http.StatusBadRequest, "%v", err)
+		return
+	}
+	sha := hex.EncodeToString(hash.Sum(nil))
+
+	var existing db.Network
+	if err := db.GetDB().Where("sha = ?", sha).First(&existing).Error; err == nil {
+		c.String(http.StatusBadRequest, "Network %s already exists", sha)
+		return
+	}
+
+	trainingRunID, _ := strconv.ParseUint(c.PostForm("training_id"), 10, 64)
+	layers, _ := strconv.Atoi(c.PostForm("layers"))
+	filters, _ := strconv.Atoi(c.PostForm("filters"))
+
+	os.MkdirAll("networks", os.ModePerm)
+	path := filepath.Join("networks", sha)
+	if err := ioutil.WriteFile(path, compressed.Bytes(), 0644); err != nil {
+		c.String(http.StatusInternalServerError, "%v", err)
+		return
+	}
+
+	network := db.Network{Sha: sha, Path: path, Layers: layers, Filters: filters}
+	if err := db.GetDB().Create(&network).Error; err != nil {
+		c.String(http.StatusInternalServerError, "%v", err)
+		return
+	}
+
+	if err := db.GetDB().Model(&db.TrainingRun{}).Where("id = ?", trainingRunID).
+		Update("best_network_id", network.ID).Error; err != nil {
+		c.String(http.StatusInternalServerError, "%v", err)
+		return
+	}
+	invalidateBestNetwork(uint(trainingRunID))
+
+	c.String(http.StatusOK, "ok")
+}
+
+// matchResultHandler records the outcome of a finished match game.
+func matchResultHandler(c *gin.Context) {
+	matchGameID, _ := strconv.ParseUint(c.PostForm("match_game_id"), 10, 64)
+	result, _ := strconv.Atoi(c.PostForm("result"))
+
+	var matchGame db.MatchGame
+	if err := db.GetDB().First(&matchGame, matchGameID).Error; err != nil {
+		c.String(http.StatusBadRequest, "Unknown match game: %d", matchGameID)
+		return
+	}
+
+	matchGame.Result = result
+	matchGame.Pgn = c.PostForm("pgn")
+	matchGame.Done = true
+	if err := db.GetDB().Save(&matchGame).Error; err != nil {
+		c.String(http.StatusInternalServerError, "%v", err)
+		return
+	}
+
+	resultLabel := "draw"
+	switch {
+	case result > 0:
+		resultLabel = "win"
+	case result < 0:
+		resultLabel = "loss"
+	}
+	metrics.MatchGames.WithLabelValues(resultLabel).Inc()
+
+	c.String(http.StatusOK, "ok")
+}
+
+// clientShutdownHandler acknowledges a worker telling the server it's
+// going away; there's nothing to persist, it just exists so clients get
+// a 200 instead of a 404 for their best-effort notification.
+func clientShutdownHandler(c *gin.Context) {
+	c.String(http.StatusOK, "ok")
+}
@@ -293,3 +293,128 @@ func (s *StoreSuite) TestPostMatchResult() {
 	assert.Equal(s.T(), "asdf", match_game.Pgn)
 	assert.Equal(s.T(), true, match_game.Done)
 }
+
+// TestRateLimitNextGame hammers /next_game as a single user well past the
+// configured burst and checks that the limiter kicks in with a 429 and a
+// Retry-After header, rather than letting the request through to GORM.
+func (s *StoreSuite) TestRateLimitNextGame() {
+	body := map[string]string{"user": "rate-limit-test", "password": "nope", "version": "2"}
+
+	for i := 0; i < DefaultRateLimitConfig.Burst*2; i++ {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/next_game", postParams(body))
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		s.router.ServeHTTP(w, req)
+
+		if w.Code == 429 {
+			assert.NotEmpty(s.T(), w.Header().Get("Retry-After"), "Retry-After header should be set")
+			return
+		}
+	}
+
+	s.T().Fatal("expected /next_game to start returning 429 once the burst allowance was exhausted")
+}
+
+// TestRateLimitUploadNetwork hammers /upload_network as a single user well
+// past the configured burst, just like TestRateLimitNextGame -- a flooding
+// client can hit this endpoint just as easily as /next_game.
+func (s *StoreSuite) TestRateLimitUploadNetwork() {
+	tmpfile, _ := ioutil.TempFile("", "example")
+	defer os.Remove(tmpfile.Name())
+	if _, err := tmpfile.Write([]byte("not a real network")); err != nil {
+		log.Fatal(err)
+	}
+	extraParams := map[string]string{"user": "rate-limit-test-upload-network"}
+
+	for i := 0; i < DefaultRateLimitConfig.Burst*2; i++ {
+		w := httptest.NewRecorder()
+		req, err := client.BuildUploadRequest("/upload_network", extraParams, "file", tmpfile.Name())
+		if err != nil {
+			log.Fatal(err)
+		}
+		s.router.ServeHTTP(w, req)
+
+		if w.Code == 429 {
+			assert.NotEmpty(s.T(), w.Header().Get("Retry-After"), "Retry-After header should be set")
+			return
+		}
+	}
+
+	s.T().Fatal("expected /upload_network to start returning 429 once the burst allowance was exhausted")
+}
+
+// TestRateLimitMatchResult hammers /match_result as a single user well past
+// the configured burst, just like TestRateLimitNextGame.
+func (s *StoreSuite) TestRateLimitMatchResult() {
+	body := map[string]string{"user": "rate-limit-test-match-result", "match_game_id": "1", "result": "0", "pgn": ""}
+
+	for i := 0; i < DefaultRateLimitConfig.Burst*2; i++ {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/match_result", postParams(body))
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		s.router.ServeHTTP(w, req)
+
+		if w.Code == 429 {
+			assert.NotEmpty(s.T(), w.Header().Get("Retry-After"), "Retry-After header should be set")
+			return
+		}
+	}
+
+	s.T().Fatal("expected /match_result to start returning 429 once the burst allowance was exhausted")
+}
+
+// TestMetricsEndpoint exercises the enabled path of registerMetricsRoute
+// directly, since setupRouter() always leaves /metrics off by default
+// (see MetricsEnabled) and s.router is built once for the whole suite.
+func (s *StoreSuite) TestMetricsEndpoint() {
+	router := gin.New()
+	registerMetricsRoute(router, MetricsConfig{Enabled: true, BearerToken: "secret"})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/metrics", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(s.T(), http.StatusUnauthorized, w.Code, "expected /metrics to require the bearer token")
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/metrics", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	router.ServeHTTP(w, req)
+	assert.Equal(s.T(), http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(s.T(), w.Body.String(), "lc_upload_bytes")
+}
+
+// TestBestNetworkCacheInvalidation checks that promoting a new best
+// network for a training run is reflected immediately once
+// invalidateBestNetwork is called, rather than only after the cache
+// entry's TTL expires.
+func (s *StoreSuite) TestBestNetworkCacheInvalidation() {
+	// SetupTest resets the tables but not the cache, so don't leave the
+	// promoted network cached for the tests that run after this one.
+	defer invalidateBestNetwork(1)
+
+	candidate := db.Network{Sha: "newbest"}
+	if err := db.GetDB().Create(&candidate).Error; err != nil {
+		log.Fatal(err)
+	}
+
+	networkID, err := cachedBestNetworkID(1)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), uint(1), networkID)
+
+	if err := db.GetDB().Model(&db.TrainingRun{}).Where("id = ?", 1).
+		Update("best_network_id", candidate.ID).Error; err != nil {
+		log.Fatal(err)
+	}
+
+	// Without invalidation the stale value should still come back from
+	// the cache.
+	networkID, err = cachedBestNetworkID(1)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), uint(1), networkID)
+
+	invalidateBestNetwork(1)
+
+	networkID, err = cachedBestNetworkID(1)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), candidate.ID, networkID)
+}
@@ -0,0 +1,69 @@
+// Package metrics defines the Prometheus collectors the training server
+// exposes on /metrics, so handlers just need to call the relevant
+// Inc/Observe/Set instead of reaching into a shared registry themselves.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// GamesUploaded counts completed training games received by
+	// /upload_game.
+	GamesUploaded = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "lc_games_uploaded_total",
+		Help: "Training games uploaded via /upload_game.",
+	}, []string{"training_run", "user"})
+
+	// MatchGames counts completed match games received by /match_result.
+	MatchGames = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "lc_match_games_total",
+		Help: "Match games completed via /match_result, labeled by result.",
+	}, []string{"result"})
+
+	// NetworkDownloads counts weight file downloads served by
+	// /get_network.
+	NetworkDownloads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "lc_network_downloads_total",
+		Help: "Network weight downloads served via /get_network.",
+	}, []string{"sha"})
+
+	// UploadBytes tracks the size of files posted to /upload_game and
+	// /upload_network.
+	UploadBytes = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "lc_upload_bytes",
+		Help:    "Size in bytes of files uploaded to /upload_game and /upload_network.",
+		Buckets: prometheus.ExponentialBuckets(1<<10, 4, 10), // 1KiB .. 256MiB
+	})
+
+	// NextGameLatency tracks how long /next_game takes to respond,
+	// labeled by the type of game it handed out ("train" or "match").
+	NextGameLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "lc_next_game_latency_seconds",
+		Help: "Latency of /next_game, labeled by the type of game handed out.",
+	}, []string{"type"})
+
+	// ActiveUsers is refreshed periodically from the DB rather than
+	// updated inline by a handler.
+	ActiveUsers = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "lc_active_users",
+		Help: "Number of distinct users who have uploaded a game recently.",
+	})
+
+	// TrainingRunBestNetwork reports the current best network ID for
+	// each active training run.
+	TrainingRunBestNetwork = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "lc_training_run_best_network",
+		Help: "ID of the current best network for a training run.",
+	}, []string{"training_run"})
+
+	// RateLimitRejections counts requests turned away by the rate
+	// limiter, labeled by whether they were keyed by user or by IP.
+	// Labeled by kind rather than the key itself, since the key (a
+	// username or IP) is unbounded cardinality.
+	RateLimitRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "lc_rate_limit_rejections_total",
+		Help: "Requests rejected by the rate limiter, labeled by key kind (user or ip).",
+	}, []string{"kind"})
+)
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"server/db"
+	"server/metrics"
+)
+
+// MetricsConfig gates the /metrics endpoint, which is off by default so
+// it's never accidentally exposed on a public deployment without a
+// bearer token.
+type MetricsConfig struct {
+	Enabled     bool   `yaml:"enabled"`
+	BearerToken string `yaml:"bearer_token"`
+}
+
+// MetricsEnabled and MetricsBearerToken mirror RedisAddr in
+// cache_config.go: flags parsed once at startup and read by setupRouter
+// to build the MetricsConfig it passes to registerMetricsRoute, so
+// turning /metrics on doesn't require touching any code.
+var (
+	MetricsEnabled     = flag.Bool("metrics", false, "Expose a /metrics endpoint for Prometheus scraping")
+	MetricsBearerToken = flag.String("metrics-bearer-token", "", "Bearer token required to read /metrics; leave empty to require none")
+)
+
+// registerMetricsRoute wires promhttp's handler onto the router behind an
+// optional bearer token, and starts a background refresh of the gauges
+// that have to be computed from the DB rather than updated incrementally
+// by a handler.
+func registerMetricsRoute(router *gin.Engine, cfg MetricsConfig) {
+	if !cfg.Enabled {
+		return
+	}
+
+	handler := promhttp.Handler()
+	router.GET("/metrics", func(c *gin.Context) {
+		if cfg.BearerToken != "" && c.GetHeader("Authorization") != "Bearer "+cfg.BearerToken {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(c.Writer, c.Request)
+	})
+
+	go refreshDBGauges()
+}
+
+// activeUserWindow is how far back a TrainingGame upload still counts
+// towards ActiveUsers -- this gauge is meant to track recent activity,
+// not lifetime signups.
+const activeUserWindow = 24 * time.Hour
+
+// refreshDBGauges periodically recomputes the gauges that aren't natural
+// fits for inline handler increments.
+func refreshDBGauges() {
+	for range time.Tick(time.Minute) {
+		var userIDs []uint
+		cutoff := time.Now().Add(-activeUserWindow)
+		err := db.GetDB().Model(&db.TrainingGame{}).Where("created_at > ?", cutoff).
+			Pluck("DISTINCT user_id", &userIDs).Error
+		if err == nil {
+			metrics.ActiveUsers.Set(float64(len(userIDs)))
+		}
+
+		var runs []db.TrainingRun
+		if err := db.GetDB().Where("active = ?", true).Find(&runs).Error; err == nil {
+			for _, run := range runs {
+				metrics.TrainingRunBestNetwork.WithLabelValues(strconv.Itoa(int(run.ID))).Set(float64(run.BestNetworkID))
+			}
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"server/db"
+	"server/metrics"
+)
+
+// networkBlobTTL is long because a network file is immutable once
+// stored: it's addressed by the sha256 of its weights, so there's
+// nothing for a cached copy to go stale against.
+const networkBlobTTL = 24 * time.Hour
+
+func networkBlobCacheKey(sha string) string {
+	return "network_blob:" + sha
+}
+
+// bundleFile mirrors client.BundleFile -- one network shard the client can
+// fetch as part of a parallel bundle download.
+type bundleFile struct {
+	Sha    string `json:"sha"`
+	Size   int64  `json:"size"`
+	Sha256 string `json:"sha256"`
+	Url    string `json:"url"`
+}
+
+// getNetwork streams a network's weights file, honoring Range requests so
+// a client resuming an interrupted download doesn't have to start over.
+// Whole-file requests (the common case -- clients only range-request to
+// resume an interrupted download) are served straight out of the cache
+// when present, skipping disk and GORM entirely.
+func getNetwork(c *gin.Context) {
+	sha := c.Query("sha")
+
+	if c.GetHeader("Range") == "" {
+		var blob []byte
+		if hit, err := netCache.Get(context.Background(), networkBlobCacheKey(sha), &blob); err == nil && hit {
+			metrics.NetworkDownloads.WithLabelValues(sha).Inc()
+			c.Data(http.StatusOK, "application/octet-stream", blob)
+			return
+		}
+	}
+
+	var network db.Network
+	if err := db.GetDB().Where("sha = ?", sha).First(&network).Error; err != nil {
+		c.String(http.StatusBadRequest, "Unknown network: %s", sha)
+		return
+	}
+
+	if _, err := os.Stat(network.Path); err != nil {
+		c.String(http.StatusInternalServerError, "Network file missing on disk")
+		return
+	}
+	metrics.NetworkDownloads.WithLabelValues(network.Sha).Inc()
+
+	if blob, err := ioutil.ReadFile(network.Path); err == nil {
+		// Networks are immutable once stored (addressed by their own
+		// sha256), so there's no invalidation to worry about here.
+		netCache.Set(context.Background(), networkBlobCacheKey(sha), blob, networkBlobTTL)
+	}
+
+	// http.ServeContent (which c.File uses under the hood) sets
+	// Content-Length/Accept-Ranges and honors Range requests for us.
+	c.File(network.Path)
+}
+
+// bundle returns the {sha, size, sha256, url} manifest for every `sha`
+// query parameter, so the client can fan the downloads out to a worker
+// pool instead of fetching shards one at a time.
+func bundle(c *gin.Context) {
+	shas := c.QueryArray("sha")
+	manifest := make([]bundleFile, 0, len(shas))
+
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+
+	for _, sha := range shas {
+		var network db.Network
+		if err := db.GetDB().Where("sha = ?", sha).First(&network).Error; err != nil {
+			continue
+		}
+		info, err := os.Stat(network.Path)
+		if err != nil {
+			continue
+		}
+		manifest = append(manifest, bundleFile{
+			Sha:    sha,
+			Size:   info.Size(),
+			Sha256: sha,
+			Url:    fmt.Sprintf("%s://%s/get_network?sha=%s", scheme, strings.TrimSuffix(c.Request.Host, "/"), sha),
+		})
+	}
+
+	c.JSON(http.StatusOK, manifest)
+}
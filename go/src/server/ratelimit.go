@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+
+	"server/metrics"
+)
+
+// RateLimitConfig holds the token-bucket settings. Its yaml tags name the
+// keys of a top-level `rate_limit:` section, but the server reads no YAML
+// file: setupRouter's limiter always uses DefaultRateLimitConfig.
+type RateLimitConfig struct {
+	RequestsPerSecond float64 `yaml:"requests_per_second"`
+	Burst             int     `yaml:"burst"`
+}
+
+// DefaultRateLimitConfig is the throttle a well-behaved training client
+// should never notice: 2 games/sec sustained, with bursts up to 10.
+var DefaultRateLimitConfig = RateLimitConfig{RequestsPerSecond: 2, Burst: 10}
+
+type limiterKey struct {
+	kind string // "user" or "ip"
+	id   string
+}
+
+// rateLimiter hands out a token-bucket limiter per user (falling back to
+// per-IP for unauthenticated requests), creating one lazily on first use,
+// and keeps a running count of rejections for abuse monitoring.
+type rateLimiter struct {
+	mu       sync.Mutex
+	cfg      RateLimitConfig
+	limiters map[limiterKey]*rate.Limiter
+	rejected map[limiterKey]int64
+}
+
+func newRateLimiter(cfg RateLimitConfig) *rateLimiter {
+	return &rateLimiter{
+		cfg:      cfg,
+		limiters: make(map[limiterKey]*rate.Limiter),
+		rejected: make(map[limiterKey]int64),
+	}
+}
+
+func (r *rateLimiter) limiterFor(key limiterKey) *rate.Limiter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	l, ok := r.limiters[key]
+	if !ok {
+		l = rate.NewLimiter(rate.Limit(r.cfg.RequestsPerSecond), r.cfg.Burst)
+		r.limiters[key] = l
+	}
+	return l
+}
+
+// RejectionCount returns how many requests have been turned away for id
+// under kind ("user" or "ip").
+func (r *rateLimiter) RejectionCount(kind, id string) int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rejected[limiterKey{kind, id}]
+}
+
+// Middleware throttles requests per-user, falling back to per-IP for
+// requests with no "user" form field, using a token bucket. Once the
+// bucket is empty it responds 429 with a Retry-After header instead of
+// calling through to the handler.
+func (r *rateLimiter) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := limiterKey{kind: "ip", id: c.ClientIP()}
+		if user := c.PostForm("user"); user != "" {
+			key = limiterKey{kind: "user", id: user}
+		}
+
+		if !r.limiterFor(key).Allow() {
+			r.mu.Lock()
+			r.rejected[key]++
+			r.mu.Unlock()
+			metrics.RateLimitRejections.WithLabelValues(key.kind).Inc()
+
+			retryAfter := 1
+			if r.cfg.RequestsPerSecond > 0 {
+				retryAfter = int(1 / r.cfg.RequestsPerSecond)
+				if retryAfter < 1 {
+					retryAfter = 1
+				}
+			}
+			c.Header("Retry-After", fmt.Sprintf("%d", retryAfter))
+			c.AbortWithStatus(http.StatusTooManyRequests)
+			return
+		}
+		c.Next()
+	}
+}
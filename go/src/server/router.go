@@ -0,0 +1,32 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// limiter throttles the endpoints training clients and match workers post
+// to on every game they play; see ratelimit.go.
+var limiter = newRateLimiter(DefaultRateLimitConfig)
+
+// setupRouter wires every route the training client and match workers
+// talk to, along with the cross-cutting middleware (rate limiting,
+// upload size limits).
+func setupRouter() *gin.Engine {
+	router := gin.Default()
+
+	router.POST("/next_game", limiter.Middleware(), nextGameHandler)
+	// limitUploadBody goes first: the limiter reads the "user" form field,
+	// which parses the whole request body.
+	router.POST("/upload_game", limitUploadBody, limiter.Middleware(), uploadGameHandler)
+	router.POST("/upload_network", limitUploadBody, limiter.Middleware(), uploadNetworkHandler)
+	router.POST("/match_result", limiter.Middleware(), matchResultHandler)
+	router.POST("/client_shutdown", clientShutdownHandler)
+	router.GET("/get_network", getNetwork)
+	router.GET("/bundle", bundle)
+
+	// Off by default; set --metrics (and --metrics-bearer-token) to turn
+	// it on. See MetricsConfig.
+	registerMetricsRoute(router, MetricsConfig{Enabled: *MetricsEnabled, BearerToken: *MetricsBearerToken})
+
+	return router
+}
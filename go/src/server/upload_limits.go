@@ -0,0 +1,35 @@
+package main
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// maxUploadBytes caps the raw request body accepted by the upload
+	// endpoints, so a single misbehaving client can't exhaust memory or
+	// disk with one request.
+	maxUploadBytes = 64 << 20 // 64MiB
+
+	// maxNetworkDecompressedBytes caps how far we'll inflate an uploaded
+	// network file, so a small malicious gzip can't be used as a
+	// decompression bomb against the server.
+	maxNetworkDecompressedBytes = 512 << 20 // 512MiB
+)
+
+// limitUploadBody wraps the request body of upload endpoints with
+// http.MaxBytesReader, so oversized uploads fail fast with an error
+// instead of being read into memory.
+func limitUploadBody(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxUploadBytes)
+	c.Next()
+}
+
+// limitedNetworkReader wraps r (a gzip reader over an uploaded network)
+// so reading more than maxNetworkDecompressedBytes out of it returns
+// io.EOF early rather than continuing to inflate an oversized payload.
+func limitedNetworkReader(r io.Reader) io.Reader {
+	return io.LimitReader(r, maxNetworkDecompressedBytes)
+}